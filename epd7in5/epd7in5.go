@@ -9,10 +9,11 @@ package epd7in5
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
-	"log"
 	"periph.io/x/conn/v3"
 	"periph.io/x/conn/v3/gpio"
 	"periph.io/x/conn/v3/gpio/gpioreg"
@@ -35,10 +36,150 @@ const (
 	PowerOffSequenceSetting byte = 0x03
 	DeepSleep               byte = 0x07
 	DataStartTransmission1  byte = 0x10
+	DataStartTransmission2  byte = 0x13
 	DisplayRefresh          byte = 0x12
+	PartialWindow           byte = 0x90
+	PartialIn               byte = 0x91
+	PartialOut              byte = 0x92
 	AutoMeasurementVcom     byte = 0x80
+	LutVcom                 byte = 0x20
+	LutWW                   byte = 0x21
+	LutBW                   byte = 0x22
+	LutWB                   byte = 0x23
+	LutBB                   byte = 0x24
 )
 
+// 4-gray LUT tables for Init4Gray, exposed as package-level vars so callers
+// can tune them per refresh mode. Each table is the vendor-documented
+// waveform for its command (0x20-0x24), laid out as six-byte groups whose
+// last byte is the group's frame-repeat count.
+var (
+	LutVcomDC = []byte{
+		0x00, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x60, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x00, 0x14, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x13, 0x0A, 0x01, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	LutWWTable = []byte{
+		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x10, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0xA0, 0x13, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	LutBWTable = []byte{
+		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x00, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0x99, 0x0C, 0x01, 0x03, 0x04, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	LutWBTable = []byte{
+		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x00, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0x99, 0x0B, 0x04, 0x04, 0x01, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	LutBBTable = []byte{
+		0x80, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x20, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0x50, 0x13, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+)
+
+// spiChunkSize is the largest single Tx transfer sendDataSlice will issue.
+// It matches the spidev ioctl buffer limit common to periph.io-based drivers.
+const spiChunkSize = 4096
+
+// PanelColor selects which panel variant Epd drives. The pin/SPI plumbing
+// in New is shared across variants; only Init and Display differ.
+type PanelColor int
+
+const (
+	// ColorBW is the default black/white panel (e.g. epd7in5_V2).
+	ColorBW PanelColor = iota
+	// ColorBWR is the black/white/red panel (e.g. epd7in5b_v2).
+	ColorBWR
+)
+
+// String returns a human-readable panel color name, for use in error
+// messages from requireColor.
+func (c PanelColor) String() string {
+	switch c {
+	case ColorBW:
+		return "BW"
+	case ColorBWR:
+		return "BWR"
+	default:
+		return "unknown"
+	}
+}
+
+// Logger receives the driver's diagnostic messages. The default, used when
+// no WithLogger Option is given to New, discards everything.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Printf(string, ...interface{}) {}
+
+// BusyTimeoutError reports that the busy pin did not go high within
+// BusyTimeout, which usually means a disconnected or mis-seated ribbon
+// cable rather than the panel actually being busy.
+type BusyTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (err *BusyTimeoutError) Error() string {
+	return fmt.Sprintf("epd: busy pin timed out after %s", err.Timeout)
+}
+
+// Option configures optional Epd behavior in New.
+type Option func(*Epd)
+
+// WithLogger sets the Logger used for diagnostic messages.
+func WithLogger(l Logger) Option {
+	return func(e *Epd) {
+		e.logger = l
+	}
+}
+
+// WithBusyTimeout sets how long waitUntilIdleCtx waits for the busy pin
+// before failing with a *BusyTimeoutError. The default, zero, waits
+// forever, matching the pre-Option behavior.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(e *Epd) {
+		e.BusyTimeout = d
+	}
+}
+
+// WithPowerPin sets the GPIO pin name that gates the panel's supply rail,
+// matching the vendor reference wiring. Init and Sleep drive it
+// automatically via PowerOn/PowerOff. If unset (the default), PowerOn and
+// PowerOff are no-ops, matching boards where PWR is tied directly to a
+// supply rail instead of a GPIO.
+func WithPowerPin(pin string) Option {
+	return func(e *Epd) {
+		e.pwrPinName = pin
+	}
+}
+
 // Epd is a handle to the display controller.
 type Epd struct {
 	c          conn.Conn
@@ -46,12 +187,31 @@ type Epd struct {
 	cs         gpio.PinOut
 	rst        gpio.PinOut
 	busy       gpio.PinIO
+	pwr        gpio.PinOut
+	pwrPinName string
 	widthByte  int
 	heightByte int
+	color      PanelColor
+	logger     Logger
+	state      state
+	// BusyTimeout bounds how long waitUntilIdleCtx waits for the busy pin.
+	// Zero means wait forever. Set via WithBusyTimeout.
+	BusyTimeout time.Duration
 }
 
+// state tracks the panel's power/init lifecycle so Sleep can short-circuit
+// a redundant transition instead of hanging in waitUntilIdle on an
+// already-off chip.
+type state int
+
+const (
+	stateOff state = iota
+	stateReady
+	stateSleeping
+)
+
 // New returns a Epd object that communicates over SPI to the display controller.
-func New(dcPin, csPin, rstPin, busyPin string) (*Epd, error) {
+func New(dcPin, csPin, rstPin, busyPin string, opts ...Option) (*Epd, error) {
 	if _, err := host.Init(); err != nil {
 		return nil, err
 	}
@@ -127,11 +287,77 @@ func New(dcPin, csPin, rstPin, busyPin string) (*Epd, error) {
 		busy:       busy,
 		widthByte:  widthByte,
 		heightByte: heightByte,
+		logger:     noopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	// PWR pin (optional; empty means the panel's supply is wired directly
+	// rather than gated by a GPIO)
+	if e.pwrPinName != "" {
+		pwr := gpioreg.ByName(e.pwrPinName)
+		if pwr == nil {
+			return nil, errors.New("spi: failed to find PWR pin")
+		}
+		if err := pwr.Out(gpio.Low); err != nil {
+			return nil, err
+		}
+		e.pwr = pwr
 	}
 
 	return e, nil
 }
 
+// NewBWR returns a Epd object wired the same as New but configured to
+// drive the black/white/red panel variant (epd7in5b_v2). Use InitBWR,
+// ConvertBWR and DisplayBWR instead of the BW-only equivalents.
+func NewBWR(dcPin, csPin, rstPin, busyPin string, opts ...Option) (*Epd, error) {
+	e, err := New(dcPin, csPin, rstPin, busyPin, opts...)
+	if err != nil {
+		return nil, err
+	}
+	e.color = ColorBWR
+	return e, nil
+}
+
+// PowerOn drives the PWR pin high and waits for the rail to settle. It is a
+// no-op if New was not given WithPowerPin. Init calls it automatically.
+func (e *Epd) PowerOn() error {
+	if e.pwr == nil {
+		return nil
+	}
+	if err := e.pwr.Out(gpio.High); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// PowerOff drives the PWR pin low and waits for the rail to settle. It is a
+// no-op if New was not given WithPowerPin. Sleep calls it automatically.
+func (e *Epd) PowerOff() error {
+	if e.pwr == nil {
+		return nil
+	}
+	if err := e.pwr.Out(gpio.Low); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// requireColor returns an error if e was not created for the given panel
+// color, so BW-only and BWR-only methods can't be called on a mismatched
+// handle.
+func (e *Epd) requireColor(want PanelColor) error {
+	if e.color != want {
+		return fmt.Errorf("epd: handle is configured for %s panels, not %s", e.color, want)
+	}
+	return nil
+}
+
 // Reset can be also used to awaken the device.
 func (e *Epd) Reset() error {
 	if err := e.rst.Out(gpio.High); err != nil {
@@ -187,30 +413,102 @@ func (e *Epd) sendData(data byte) error {
 	return nil
 }
 
-// WaitUntilIdle waits until the display is idle.
+// sendDataSlice writes a whole payload under a single DC/CS assertion,
+// chunking the transfer into segments of at most spiChunkSize bytes. This
+// avoids the overhead of toggling DC/CS around every byte for large
+// transfers such as a full-frame Display or Clear.
+func (e *Epd) sendDataSlice(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := e.dc.Out(gpio.High); err != nil {
+		return err
+	}
+	if err := e.cs.Out(gpio.Low); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > spiChunkSize {
+			n = spiChunkSize
+		}
+		if err := e.c.Tx(data[:n], nil); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	if err := e.cs.Out(gpio.High); err != nil {
+		return err
+	}
+	return nil
+}
+
+// waitUntilIdle waits until the display is idle or BusyTimeout elapses,
+// without context cancellation; a timeout error is discarded. It exists for
+// call sites that predate context support; prefer waitUntilIdleCtx in new code.
 func (e *Epd) waitUntilIdle() {
-	log.Println("e-paper busy")
+	_ = e.waitUntilIdleCtx(context.Background())
+}
+
+// waitUntilIdleCtx waits until the busy pin goes high, returning
+// ctx.Err() if ctx is cancelled first or a *BusyTimeoutError if
+// e.BusyTimeout elapses first.
+func (e *Epd) waitUntilIdleCtx(ctx context.Context) error {
+	e.logger.Printf("e-paper busy")
+
+	var timeoutC <-chan time.Time
+	if e.BusyTimeout > 0 {
+		timer := time.NewTimer(e.BusyTimeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
 	for e.busy.Read() == gpio.Low {
-		time.Sleep(5 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeoutC:
+			return &BusyTimeoutError{Timeout: e.BusyTimeout}
+		case <-time.After(5 * time.Millisecond):
+		}
 	}
 	time.Sleep(5 * time.Millisecond)
-	log.Println("e-paper busy release")
+	e.logger.Printf("e-paper busy release")
+	return nil
 }
 
-// TurnOnDisplay Turns on the display.
+// turnOnDisplay turns on the display, ignoring context cancellation. See
+// turnOnDisplayCtx for the cancellable variant.
 func (e *Epd) turnOnDisplay() error {
+	return e.turnOnDisplayCtx(context.Background())
+}
+
+// turnOnDisplayCtx turns on the display and waits for it to go idle,
+// honoring ctx and BusyTimeout.
+func (e *Epd) turnOnDisplayCtx(ctx context.Context) error {
 	if err := e.sendCommand(DisplayRefresh); err != nil {
 		return err
 	}
 	time.Sleep(100 * time.Millisecond)
-	e.waitUntilIdle()
-	return nil
+	return e.waitUntilIdleCtx(ctx)
 }
 
 // Init initializes the display config.
 // It should be only used when you put the device to sleep and need to re-init the device.
 func (e *Epd) Init() error {
-	log.Println("e-paper init")
+	return e.InitCtx(context.Background())
+}
+
+// InitCtx is Init, but aborts with ctx.Err() or a *BusyTimeoutError instead
+// of hanging forever if the panel's busy pin never goes high.
+func (e *Epd) InitCtx(ctx context.Context) error {
+	if err := e.requireColor(ColorBW); err != nil {
+		return err
+	}
+	e.logger.Printf("e-paper init")
+	if err := e.PowerOn(); err != nil {
+		return err
+	}
 	if err := e.Reset(); err != nil {
 		return err
 	}
@@ -218,33 +516,14 @@ func (e *Epd) Init() error {
 	if err := e.sendCommand(PowerSetting); err != nil {
 		return err
 	} //POWER SETTING
-
-	if err := e.sendData(0x07); err != nil {
-		return err
-	}
-	if err := e.sendData(0x07); err != nil {
+	if err := e.sendDataSlice([]byte{0x07, 0x07, 0x3f, 0x3f}); err != nil {
 		return err
-	} //VGH=20V,VGL=-20V
-	if err := e.sendData(0x3f); err != nil {
-		return err
-	} //VDH=15V
-	if err := e.sendData(0x3f); err != nil {
-		return err
-	} //VDL=-15V
+	} //VGH=20V,VGL=-20V, VDH=15V, VDL=-15V
 
 	if err := e.sendCommand(0x06); err != nil {
 		return err
 	}
-	if err := e.sendData(0x17); err != nil {
-		return err
-	}
-	if err := e.sendData(0x17); err != nil {
-		return err
-	}
-	if err := e.sendData(0x28); err != nil {
-		return err
-	}
-	if err := e.sendData(0x17); err != nil {
+	if err := e.sendDataSlice([]byte{0x17, 0x17, 0x28, 0x17}); err != nil {
 		return err
 	}
 
@@ -252,77 +531,154 @@ func (e *Epd) Init() error {
 		return err
 	} //POWER ON
 	time.Sleep(100 * time.Millisecond)
-	e.waitUntilIdle()
+	if err := e.waitUntilIdleCtx(ctx); err != nil {
+		return err
+	}
 
 	if err := e.sendCommand(0x00); err != nil {
 		return err
 	} //PANNEL SETTING
-	if err := e.sendData(0x1F); err != nil {
+	if err := e.sendDataSlice([]byte{0x1F}); err != nil {
 		return err
 	} //KW-3f   KWR-2F	BWROTP 0f	BWOTP 1f
 
 	if err := e.sendCommand(0x61); err != nil {
 		return err
 	}
-	if err := e.sendData(0x03); err != nil {
+	if err := e.sendDataSlice([]byte{0x03, 0x20, 0x01, 0xE0}); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(0x15); err != nil {
 		return err
 	}
-	if err := e.sendData(0x20); err != nil {
+	if err := e.sendDataSlice([]byte{0x00}); err != nil {
 		return err
 	}
-	if err := e.sendData(0x01); err != nil {
+
+	if err := e.sendCommand(0x50); err != nil {
 		return err
 	}
-	if err := e.sendData(0xE0); err != nil {
+	if err := e.sendDataSlice([]byte{0x10, 0x07}); err != nil {
 		return err
 	}
 
-	if err := e.sendCommand(0x15); err != nil {
+	if err := e.sendCommand(0x60); err != nil {
 		return err
 	}
-	if err := e.sendData(0x00); err != nil {
+	if err := e.sendDataSlice([]byte{0x22}); err != nil {
+		return err
+	}
+	e.state = stateReady
+	return nil
+}
+
+func (e *Epd) InitFast() error {
+	if err := e.requireColor(ColorBW); err != nil {
+		return err
+	}
+	if err := e.PowerOn(); err != nil {
+		return err
+	}
+	if err := e.Reset(); err != nil {
+		return err
+	}
+	if err := e.sendCommand(0x00); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x1F}); err != nil {
 		return err
 	}
 
 	if err := e.sendCommand(0x50); err != nil {
 		return err
 	}
-	if err := e.sendData(0x10); err != nil {
+	if err := e.sendDataSlice([]byte{0x10, 0x07}); err != nil {
 		return err
 	}
-	if err := e.sendData(0x07); err != nil {
+
+	if err := e.sendCommand(0x04); err != nil {
+		return err
+	} //POWER ON
+	time.Sleep(100 * time.Millisecond)
+	e.waitUntilIdle()
+
+	if err := e.sendCommand(0x06); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x27, 0x27, 0x18, 0x17}); err != nil {
 		return err
 	}
 
-	if err := e.sendCommand(0x60); err != nil {
+	if err := e.sendCommand(0xE0); err != nil {
 		return err
 	}
-	if err := e.sendData(0x22); err != nil {
+	if err := e.sendDataSlice([]byte{0x02, 0xE5, 0x5A}); err != nil {
 		return err
 	}
+
+	e.state = stateReady
 	return nil
 }
 
-func (e *Epd) InitFast() error {
+func (e *Epd) InitPart() error {
+	if err := e.requireColor(ColorBW); err != nil {
+		return err
+	}
+	if err := e.PowerOn(); err != nil {
+		return err
+	}
 	if err := e.Reset(); err != nil {
 		return err
 	}
 	if err := e.sendCommand(0x00); err != nil {
 		return err
 	}
-	if err := e.sendData(0x1F); err != nil {
+	if err := e.sendDataSlice([]byte{0x1F}); err != nil {
 		return err
 	}
 
-	if err := e.sendCommand(0x50); err != nil {
+	if err := e.sendCommand(0x04); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	e.waitUntilIdle()
+
+	if err := e.sendCommand(0xE0); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x02}); err != nil {
+		return err
+	}
+	if err := e.sendCommand(0xE5); err != nil {
 		return err
 	}
-	if err := e.sendData(0x10); err != nil {
+	if err := e.sendDataSlice([]byte{0x6E}); err != nil {
 		return err
 	}
-	if err := e.sendData(0x07); err != nil {
+	e.state = stateReady
+	return nil
+}
+
+// Init4Gray initializes the display for the 4-level grayscale LUT mode
+// instead of the default 1-bit black/white mode.
+func (e *Epd) Init4Gray() error {
+	if err := e.requireColor(ColorBW); err != nil {
 		return err
 	}
+	if err := e.PowerOn(); err != nil {
+		return err
+	}
+	if err := e.Reset(); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(PowerSetting); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x07, 0x07, 0x3f, 0x3f}); err != nil {
+		return err
+	} //VGH=20V,VGL=-20V, VDH=15V, VDL=-15V
 
 	if err := e.sendCommand(0x04); err != nil {
 		return err
@@ -330,99 +686,311 @@ func (e *Epd) InitFast() error {
 	time.Sleep(100 * time.Millisecond)
 	e.waitUntilIdle()
 
+	if err := e.sendCommand(PanelSetting); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x3F}); err != nil {
+		return err
+	}
+
 	if err := e.sendCommand(0x06); err != nil {
 		return err
+	} //booster soft start
+	if err := e.sendDataSlice([]byte{0x17, 0x17, 0x28, 0x17}); err != nil {
+		return err
 	}
-	if err := e.sendData(0x27); err != nil {
+
+	if err := e.sendCommand(LutVcom); err != nil {
 		return err
 	}
-	if err := e.sendData(0x27); err != nil {
+	if err := e.sendDataSlice(LutVcomDC); err != nil {
 		return err
 	}
-	if err := e.sendData(0x18); err != nil {
+
+	if err := e.sendCommand(LutWW); err != nil {
 		return err
 	}
-	if err := e.sendData(0x17); err != nil {
+	if err := e.sendDataSlice(LutWWTable); err != nil {
 		return err
 	}
 
-	if err := e.sendCommand(0xE0); err != nil {
+	if err := e.sendCommand(LutBW); err != nil {
 		return err
 	}
-	if err := e.sendData(0x02); err != nil {
+	if err := e.sendDataSlice(LutBWTable); err != nil {
 		return err
 	}
-	if err := e.sendData(0xE5); err != nil {
+
+	if err := e.sendCommand(LutWB); err != nil {
 		return err
 	}
-	if err := e.sendData(0x5A); err != nil {
+	if err := e.sendDataSlice(LutWBTable); err != nil {
 		return err
 	}
 
+	if err := e.sendCommand(LutBB); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice(LutBBTable); err != nil {
+		return err
+	}
+
+	e.state = stateReady
 	return nil
 }
 
-func (e *Epd) InitPart() error {
+// Convert4Gray quantizes img against the 4-gray palette (black, dark gray,
+// light gray, white) and packs the result two pixels per byte, one nibble
+// per pixel, ready for Display4Gray.
+func (e *Epd) Convert4Gray(img image.Image) []byte {
+	palette := color.Palette([]color.Color{color.Black, color.Gray{Y: 0x55}, color.Gray{Y: 0xAA}, color.White})
+
+	buffer := make([]byte, (Epd7in5V2Width/2)*Epd7in5V2Height)
+
+	for j := 0; j < Epd7in5V2Height; j++ {
+		for i := 0; i < Epd7in5V2Width; i += 2 {
+			var v0, v1 byte = 3, 3
+
+			if i < img.Bounds().Dx() && j < img.Bounds().Dy() {
+				v0 = byte(palette.Index(img.At(i, j)))
+			}
+			if i+1 < img.Bounds().Dx() && j < img.Bounds().Dy() {
+				v1 = byte(palette.Index(img.At(i+1, j)))
+			}
+
+			buffer[(i/2)+(j*(Epd7in5V2Width/2))] = (v0 << 4) | v1
+		}
+	}
+
+	return buffer
+}
+
+// Display4Gray splits a Convert4Gray buffer into its MSB and LSB bit-planes
+// and streams them via DataStartTransmission1 and DataStartTransmission2
+// respectively before triggering a refresh. Init4Gray must have been
+// called first.
+func (e *Epd) Display4Gray(buf []byte) error {
+	if err := e.requireColor(ColorBW); err != nil {
+		return err
+	}
+
+	widthByte := Epd7in5V2Width / 8
+	rowBytes := Epd7in5V2Width / 2
+
+	msb := make([]byte, widthByte*e.heightByte)
+	lsb := make([]byte, widthByte*e.heightByte)
+
+	for j := 0; j < e.heightByte; j++ {
+		var msbByte, lsbByte byte
+		for i := 0; i < Epd7in5V2Width; i++ {
+			srcByte := buf[j*rowBytes+i/2]
+			var v byte
+			if i%2 == 0 {
+				v = (srcByte >> 4) & 0x03
+			} else {
+				v = srcByte & 0x03
+			}
+
+			msbByte = (msbByte << 1) | ((v >> 1) & 0x01)
+			lsbByte = (lsbByte << 1) | (v & 0x01)
+
+			if i%8 == 7 {
+				msb[(i/8)+(j*widthByte)] = msbByte
+				lsb[(i/8)+(j*widthByte)] = lsbByte
+				msbByte, lsbByte = 0, 0
+			}
+		}
+	}
+
+	if err := e.sendCommand(DataStartTransmission1); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice(msb); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(DataStartTransmission2); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice(lsb); err != nil {
+		return err
+	}
+
+	return e.turnOnDisplay()
+}
+
+// InitBWR initializes the display for the black/white/red panel variant.
+// It should be used instead of Init when the Epd was created with NewBWR.
+func (e *Epd) InitBWR() error {
+	if err := e.requireColor(ColorBWR); err != nil {
+		return err
+	}
+	if err := e.PowerOn(); err != nil {
+		return err
+	}
 	if err := e.Reset(); err != nil {
 		return err
 	}
-	if err := e.sendCommand(0x00); err != nil {
+
+	if err := e.sendCommand(PanelSetting); err != nil {
 		return err
 	}
-	if err := e.sendData(0x1F); err != nil {
+	if err := e.sendDataSlice([]byte{0x0F}); err != nil {
+		return err
+	} //PSR: BWR, LUT from OTP
+
+	if err := e.sendCommand(PowerSetting); err != nil {
 		return err
 	}
+	if err := e.sendDataSlice([]byte{0x07, 0x07, 0x3f, 0x3f}); err != nil {
+		return err
+	} //VGH=20V,VGL=-20V, VDH=15V, VDL=-15V
 
 	if err := e.sendCommand(0x04); err != nil {
 		return err
-	}
+	} //POWER ON
 	time.Sleep(100 * time.Millisecond)
 	e.waitUntilIdle()
 
-	if err := e.sendCommand(0xE0); err != nil {
+	if err := e.sendCommand(0x61); err != nil {
 		return err
 	}
-	if err := e.sendData(0x02); err != nil {
+	if err := e.sendDataSlice([]byte{0x03, 0x20, 0x01, 0xE0}); err != nil {
 		return err
 	}
-	if err := e.sendCommand(0xE5); err != nil {
+
+	if err := e.sendCommand(0x15); err != nil {
 		return err
 	}
-	if err := e.sendData(0x6E); err != nil {
+	if err := e.sendDataSlice([]byte{0x00}); err != nil {
 		return err
 	}
+
+	if err := e.sendCommand(0x50); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x11, 0x07}); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(0x60); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{0x22}); err != nil {
+		return err
+	}
+
+	e.state = stateReady
 	return nil
 }
 
+// ConvertBWR classifies img against a three-entry black/white/red palette
+// and returns the two packed 1bpp planes DisplayBWR expects. Both planes
+// are active-low, matching how DisplayBWR streams them verbatim: a buffer
+// bit of 1 means the pixel is "off" for that plane (white for black, no
+// red for red).
+func (e *Epd) ConvertBWR(img image.Image) (black, red []byte) {
+	palette := color.Palette([]color.Color{color.Black, color.White, color.RGBA{R: 0xFF, A: 0xFF}})
+
+	black = bytes.Repeat([]byte{0xFF}, e.widthByte*e.heightByte)
+	red = bytes.Repeat([]byte{0xFF}, e.widthByte*e.heightByte)
+
+	var blackByte byte = 0xFF
+	var redByte byte = 0xFF
+
+	for j := 0; j < Epd7in5V2Height; j++ {
+		for i := 0; i < Epd7in5V2Width; i++ {
+			idx := 1 // default to white outside the source image bounds
+
+			if i < img.Bounds().Dx() && j < img.Bounds().Dy() {
+				idx = palette.Index(img.At(i, j))
+			}
+
+			switch idx {
+			case 0: // black
+				blackByte &^= 0x80 >> (uint(i) % 8)
+			case 2: // red
+				redByte &^= 0x80 >> (uint(i) % 8)
+			}
+
+			if i%8 == 7 {
+				black[(i/8)+(j*e.widthByte)] = blackByte
+				red[(i/8)+(j*e.widthByte)] = redByte
+				blackByte, redByte = 0xFF, 0xFF
+			}
+		}
+	}
+
+	return black, red
+}
+
+// DisplayBWR takes the black and red planes from ConvertBWR and updates
+// the screen.
+func (e *Epd) DisplayBWR(black, red []byte) error {
+	if err := e.requireColor(ColorBWR); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(DataStartTransmission1); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice(black); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(DataStartTransmission2); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice(red); err != nil {
+		return err
+	}
+
+	return e.turnOnDisplay()
+}
+
 // Clear clears the screen.
 func (e *Epd) Clear() error {
-	if err := e.sendCommand(DataStartTransmission1); err != nil {
+	return e.ClearCtx(context.Background())
+}
+
+// ClearCtx is Clear, but aborts with ctx.Err() or a *BusyTimeoutError
+// instead of hanging forever if the panel's busy pin never goes high.
+func (e *Epd) ClearCtx(ctx context.Context) error {
+	if err := e.requireColor(ColorBW); err != nil {
 		return err
 	}
 
-	for j := 0; j < e.heightByte; j++ {
-		for i := 0; i < e.widthByte; i++ {
-			for k := 0; k < 4; k++ {
-				if err := e.sendData(0x33); err != nil {
-					return err
-				}
-			}
-		}
+	if err := e.sendCommand(DataStartTransmission1); err != nil {
+		return err
 	}
 
-	if err := e.turnOnDisplay(); err != nil {
+	buffer := bytes.Repeat([]byte{0x33}, e.widthByte*e.heightByte*4)
+	if err := e.sendDataSlice(buffer); err != nil {
 		return err
 	}
-	return nil
+
+	return e.turnOnDisplayCtx(ctx)
 }
 
 // Display takes a byte buffer and updates the screen.
 func (e *Epd) Display(img []byte) error {
-	log.Println("Start e-paper display")
+	return e.DisplayCtx(context.Background(), img)
+}
+
+// DisplayCtx is Display, but aborts with ctx.Err() or a *BusyTimeoutError
+// instead of hanging forever if the panel's busy pin never goes high.
+func (e *Epd) DisplayCtx(ctx context.Context, img []byte) error {
+	if err := e.requireColor(ColorBW); err != nil {
+		return err
+	}
+
+	e.logger.Printf("Start e-paper display")
 	if err := e.sendCommand(DataStartTransmission1); err != nil {
 		return err
 	}
 
+	buffer := make([]byte, 0, e.widthByte*e.heightByte*4)
+
 	for j := 0; j < e.heightByte; j++ {
 		for i := 0; i < e.widthByte; i++ {
 			dataBlack := ^img[i+j*e.widthByte]
@@ -448,33 +1016,111 @@ func (e *Epd) Display(img []byte) error {
 
 				dataBlack <<= 1
 
-				if err := e.sendData(data); err != nil {
-					return err
-				}
+				buffer = append(buffer, data)
 			}
 		}
 	}
-	log.Println("End e-paper display image process")
-	if err := e.turnOnDisplay(); err != nil {
+	e.logger.Printf("End e-paper display image process")
+	if err := e.sendDataSlice(buffer); err != nil {
+		return err
+	}
+	if err := e.turnOnDisplayCtx(ctx); err != nil {
 		return err
 	}
-	log.Println("End e-paper display")
+	e.logger.Printf("End e-paper display")
 	return nil
 }
 
+// DisplayPartial pushes a partial-window refresh covering the rectangle at
+// (x, y) with size (w, h), without the ~3s full-panel flash of Display.
+// InitPart must have been called first. x and w are rounded outward to the
+// nearest 8-pixel boundary because the panel only accepts byte-aligned
+// horizontal coordinates; img must be a packed buffer sized for that
+// aligned window, as produced by ConvertRegion.
+func (e *Epd) DisplayPartial(img []byte, x, y, w, h int) error {
+	if err := e.requireColor(ColorBW); err != nil {
+		return err
+	}
+
+	if x < 0 || y < 0 || w <= 0 || h <= 0 {
+		return fmt.Errorf("epd: invalid partial window %dx%d at (%d,%d)", w, h, x, y)
+	}
+	if x+w > Epd7in5V2Width || y+h > Epd7in5V2Height {
+		return fmt.Errorf("epd: partial window %dx%d at (%d,%d) exceeds panel bounds %dx%d", w, h, x, y, Epd7in5V2Width, Epd7in5V2Height)
+	}
+
+	xStart := x &^ 7
+	alignedWidth := (x+w+7)&^7 - xStart
+	xEnd := xStart + alignedWidth - 1
+	yStart := y
+	yEnd := y + h - 1
+
+	if wantLen := (alignedWidth / 8) * h; len(img) != wantLen {
+		return fmt.Errorf("epd: partial window buffer is %d bytes, want %d for the %d-aligned %dx%d window", len(img), wantLen, alignedWidth, w, h)
+	}
+
+	if err := e.sendCommand(PartialIn); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(PartialWindow); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice([]byte{
+		byte(xStart >> 8), byte(xStart & 0xF8),
+		byte(xEnd >> 8), byte((xEnd & 0xF8) | 0x07),
+		byte(yStart >> 8), byte(yStart & 0xFF),
+		byte(yEnd >> 8), byte(yEnd & 0xFF),
+		0x01,
+	}); err != nil {
+		return err
+	}
+
+	if err := e.sendCommand(DataStartTransmission2); err != nil {
+		return err
+	}
+	if err := e.sendDataSlice(img); err != nil {
+		return err
+	}
+
+	if err := e.turnOnDisplay(); err != nil {
+		return err
+	}
+
+	return e.sendCommand(PartialOut)
+}
+
 // Sleep puts the display in power-saving mode.
 // You can use Reset() to awaken and Init() to re-initialize the display.
 func (e *Epd) Sleep() error {
+	return e.SleepCtx(context.Background())
+}
+
+// SleepCtx is Sleep, but aborts with ctx.Err() or a *BusyTimeoutError
+// instead of hanging forever if the panel's busy pin never goes high.
+// Calling it when the display is already asleep is a no-op; otherwise the
+// chip being powered down would make it hang in waitUntilIdleCtx.
+func (e *Epd) SleepCtx(ctx context.Context) error {
+	if e.state == stateSleeping {
+		return nil
+	}
+
 	if err := e.sendCommand(PowerOff); err != nil {
 		return err
 	}
-	e.waitUntilIdle()
+	if err := e.waitUntilIdleCtx(ctx); err != nil {
+		return err
+	}
 	if err := e.sendCommand(DeepSleep); err != nil {
 		return err
 	}
 	if err := e.sendData(0xA5); err != nil {
 		return err
 	}
+	if err := e.PowerOff(); err != nil {
+		return err
+	}
+	e.state = stateSleeping
 	return nil
 }
 
@@ -506,3 +1152,132 @@ func (e *Epd) Convert(img image.Image) []byte {
 
 	return buffer
 }
+
+// Canvas is a draw.Image backed directly by a packed 1bpp buffer in the
+// same bit convention as Convert (a set bit is white), so it can be handed
+// straight to DisplayCanvas without a separate Convert pass. This lets
+// callers compose text and shapes with image/draw and golang.org/x/image/font
+// incrementally instead of rendering a full intermediate image.Image first.
+type Canvas struct {
+	rect   image.Rectangle
+	stride int // bytes per row
+	buf    []byte
+}
+
+// NewCanvas returns a full-panel-sized Canvas, initialized to white.
+func NewCanvas() *Canvas {
+	return newCanvas(image.Rect(0, 0, Epd7in5V2Width, Epd7in5V2Height))
+}
+
+func newCanvas(r image.Rectangle) *Canvas {
+	stride := (r.Dx() + 7) / 8
+	return &Canvas{
+		rect:   r,
+		stride: stride,
+		buf:    bytes.Repeat([]byte{0xFF}, stride*r.Dy()),
+	}
+}
+
+// ColorModel implements draw.Image.
+func (c *Canvas) ColorModel() color.Model {
+	return color.Palette{color.Black, color.White}
+}
+
+// Bounds implements draw.Image.
+func (c *Canvas) Bounds() image.Rectangle {
+	return c.rect
+}
+
+// At implements draw.Image.
+func (c *Canvas) At(x, y int) color.Color {
+	if (image.Point{X: x, Y: y}.In(c.rect)) && c.bitSet(x, y) {
+		return color.White
+	}
+	return color.Black
+}
+
+func (c *Canvas) bitSet(x, y int) bool {
+	x -= c.rect.Min.X
+	y -= c.rect.Min.Y
+	return c.buf[(x/8)+(y*c.stride)]&(0x80>>uint(x%8)) != 0
+}
+
+// Set implements draw.Image.
+func (c *Canvas) Set(x, y int, clr color.Color) {
+	if !(image.Point{X: x, Y: y}.In(c.rect)) {
+		return
+	}
+	x -= c.rect.Min.X
+	y -= c.rect.Min.Y
+
+	mask := byte(0x80 >> uint(x%8))
+	if color.Palette([]color.Color{color.Black, color.White}).Index(clr) == 1 {
+		c.buf[(x/8)+(y*c.stride)] |= mask
+	} else {
+		c.buf[(x/8)+(y*c.stride)] &^= mask
+	}
+}
+
+// Clear fills the whole canvas with clr.
+func (c *Canvas) Clear(clr color.Color) {
+	var fill byte = 0x00
+	if color.Palette([]color.Color{color.Black, color.White}).Index(clr) == 1 {
+		fill = 0xFF
+	}
+	for i := range c.buf {
+		c.buf[i] = fill
+	}
+}
+
+// SubImage returns a new Canvas over the portion of c within r, suitable
+// for DisplayCanvas, which sends it with a DisplayPartial refresh. As with
+// DisplayPartial, r should already be 8-aligned on X and width.
+func (c *Canvas) SubImage(r image.Rectangle) *Canvas {
+	r = r.Intersect(c.rect)
+	sub := newCanvas(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sub.Set(x, y, c.At(x, y))
+		}
+	}
+	return sub
+}
+
+// DisplayCanvas renders c to the screen, using a full Display refresh when
+// c covers the whole panel and a DisplayPartial refresh otherwise.
+func (e *Epd) DisplayCanvas(c *Canvas) error {
+	if c.rect.Eq(image.Rect(0, 0, Epd7in5V2Width, Epd7in5V2Height)) {
+		return e.Display(c.buf)
+	}
+	return e.DisplayPartial(c.buf, c.rect.Min.X, c.rect.Min.Y, c.rect.Dx(), c.rect.Dy())
+}
+
+// ConvertRegion converts the portion of img within bounds into a packed
+// buffer sized for that region only, for use with DisplayPartial. bounds
+// should already be 8-aligned on X and width, matching the window
+// DisplayPartial computes.
+func ConvertRegion(img image.Image, bounds image.Rectangle) []byte {
+	width := bounds.Dx()
+	height := bounds.Dy()
+	widthByte := (width + 7) / 8
+
+	var byteToSend byte = PanelSetting
+	buffer := bytes.Repeat([]byte{PanelSetting}, widthByte*height)
+
+	for j := 0; j < height; j++ {
+		for i := 0; i < width; i++ {
+			bit := color.Palette([]color.Color{color.Black, color.White}).Index(img.At(bounds.Min.X+i, bounds.Min.Y+j))
+
+			if bit == 1 {
+				byteToSend |= AutoMeasurementVcom >> (uint32(i) % 8)
+			}
+
+			if i%8 == 7 || i == width-1 {
+				buffer[(i/8)+(j*widthByte)] = byteToSend
+				byteToSend = PanelSetting
+			}
+		}
+	}
+
+	return buffer
+}